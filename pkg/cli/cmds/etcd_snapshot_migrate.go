@@ -0,0 +1,22 @@
+package cmds
+
+import (
+	"github.com/urfave/cli/v2"
+)
+
+// EtcdSnapshotMigrateTagsCommand is "migrate-tags", the `k3s etcd-snapshot
+// migrate-tags` subcommand that makes snapshotstore.MigrateToTaggedSnapshots
+// reachable by an operator: it tags an existing bucket's untagged snapshots
+// so switching to --etcd-s3-retention-mode=lifecycle doesn't immediately
+// expire backups the lifecycle rule's tag filter can't see yet. action is
+// supplied by the etcd package, which has the newSnapshotStore/
+// MigrateToTaggedSnapshots wiring this package doesn't import.
+func NewEtcdSnapshotMigrateTagsCommand(action func(*cli.Context) error) *cli.Command {
+	return &cli.Command{
+		Name:      "migrate-tags",
+		Usage:     "Tag existing untagged etcd snapshots so lifecycle-mode retention can find them",
+		UsageText: "k3s etcd-snapshot migrate-tags [OPTIONS]",
+		Action:    action,
+		Flags:     NewEtcdSnapshotFlags(),
+	}
+}
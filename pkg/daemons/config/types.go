@@ -0,0 +1,98 @@
+package config
+
+import "time"
+
+// Control holds the server-side configuration fields referenced by the
+// etcd snapshot manager and its storage backends (pkg/etcd, pkg/etcd/
+// snapshotstore). It is not the full k3s server config - only the fields
+// those packages read or mutate are declared here.
+type Control struct {
+	DataDir                 string
+	ServerNodeName          string
+	ClusterResetRestorePath string
+
+	EtcdSnapshotDir       string
+	EtcdSnapshotName      string
+	EtcdSnapshotCron      string
+	EtcdSnapshotRetention int
+	// EtcdSnapshotIntervalDays overrides the retention-days-per-snapshot
+	// interval lifecycle mode derives from EtcdSnapshotCron, for schedules
+	// the cron step parser can't work out on its own.
+	EtcdSnapshotIntervalDays int
+
+	// EtcdBackupBackend selects the snapshotstore backend ("", "s3",
+	// "azure", or "gcs"; empty defaults to s3 for backwards compatibility
+	// with the legacy --etcd-s3 flags).
+	EtcdBackupBackend string
+
+	// EtcdS3 is the legacy flag that enables S3 snapshot storage; new
+	// deployments should prefer --etcd-backup-backend=s3.
+	EtcdS3              bool
+	EtcdS3Endpoint      string
+	EtcdS3EndpointCA    string
+	EtcdS3SkipSSLVerify bool
+	EtcdS3AccessKey     string
+	EtcdS3SecretKey     string
+	EtcdS3BucketName    string
+	EtcdS3Region        string
+	EtcdS3Folder        string
+	EtcdS3Insecure      bool
+	EtcdS3Timeout       time.Duration
+	// EtcdS3ClusterID is recorded as a snapshot tag/metadata value so a
+	// shared bucket can be filtered back down to one cluster's snapshots.
+	EtcdS3ClusterID string
+	// EtcdS3TagFilter restricts List/Retention to objects whose tags match
+	// every "key=value" pair in this comma-separated list.
+	EtcdS3TagFilter string
+
+	// EtcdS3SSEType selects server-side encryption for S3 uploads: "",
+	// "SSE-S3", "SSE-KMS", or "SSE-C".
+	EtcdS3SSEType     string
+	EtcdS3SSEKMSKeyID string
+	EtcdS3SSECKeyFile string
+
+	// EtcdS3RetentionMode, when set to "lifecycle", delegates snapshot
+	// retention to an S3 bucket lifecycle rule instead of client-side
+	// ListObjects/RemoveObject pruning. EtcdS3RetentionDays overrides the
+	// rule's Expiration.Days; when unset it's derived from
+	// EtcdSnapshotRetention and the snapshot interval.
+	EtcdS3RetentionMode string
+	EtcdS3RetentionDays int
+
+	// EtcdS3ObjectLockMode enables S3 Object Lock (WORM) retention on
+	// upload: "" (disabled), "GOVERNANCE", or "COMPLIANCE".
+	EtcdS3ObjectLockMode             string
+	EtcdS3ObjectLockRetention        time.Duration
+	EtcdS3LegalHold                  bool
+	EtcdS3ObjectLockBypassGovernance bool
+
+	// EtcdS3PartSize/EtcdS3ParallelUploads/EtcdS3MaxRetries tune multipart
+	// upload/download: the size of each part in bytes, the upload thread
+	// count (without a part size) or number of concurrent ranged downloads
+	// (with one), and how many times to retry a failed upload.
+	// Leaving them unset (0) preserves the original single-FPutObject,
+	// NumThreads: 2 behavior.
+	EtcdS3PartSize        int
+	EtcdS3ParallelUploads int
+	EtcdS3MaxRetries      int
+
+	// EtcdAzureAccountName/AccountKey/Container/Endpoint configure the
+	// Azure Blob Storage backend. EtcdAzureFolder and EtcdAzureTimeout are
+	// Azure's own equivalents of EtcdS3Folder/EtcdS3Timeout, kept separate
+	// so an operator can run S3 and Azure snapshot stores with different
+	// settings.
+	EtcdAzureAccountName string
+	EtcdAzureAccountKey  string
+	EtcdAzureContainer   string
+	EtcdAzureEndpoint    string
+	EtcdAzureFolder      string
+	EtcdAzureTimeout     time.Duration
+
+	// EtcdGCSBucketName/CredentialsFile configure the Google Cloud Storage
+	// backend. EtcdGCSFolder and EtcdGCSTimeout are GCS's own equivalents
+	// of EtcdS3Folder/EtcdS3Timeout.
+	EtcdGCSBucketName      string
+	EtcdGCSCredentialsFile string
+	EtcdGCSFolder          string
+	EtcdGCSTimeout         time.Duration
+}
@@ -0,0 +1,257 @@
+package snapshotstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// AzureConfig is the connection information recorded against a snapshot
+// uploaded to Azure Blob Storage, so that a restore on a fresh node knows
+// how to fetch it back again.
+type AzureConfig struct {
+	Account   string
+	Container string
+	Folder    string
+}
+
+// azureStore is the Azure Blob Storage implementation of SnapshotStore.
+type azureStore struct {
+	config    *config.Control
+	container azblob.ContainerURL
+}
+
+// NewAzureStore creates a SnapshotStore backed by Azure Blob Storage, using
+// the EtcdAzure* fields of config.Control.
+func NewAzureStore(ctx context.Context, config *config.Control) (SnapshotStore, error) {
+	if config.EtcdAzureContainer == "" {
+		return nil, errors.New("azure storage container was not set")
+	}
+	if config.EtcdAzureAccountName == "" || config.EtcdAzureAccountKey == "" {
+		return nil, errors.New("azure storage account name and key must both be set")
+	}
+
+	cred, err := azblob.NewSharedKeyCredential(config.EtcdAzureAccountName, config.EtcdAzureAccountKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid azure storage account credentials")
+	}
+
+	endpoint := config.EtcdAzureEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", config.EtcdAzureAccountName)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid azure storage endpoint")
+	}
+	u.Path = path.Join(u.Path, config.EtcdAzureContainer)
+
+	pipeline := azblob.NewPipeline(cred, azblob.PipelineOptions{})
+	container := azblob.NewContainerURL(*u, pipeline)
+
+	toCtx, cancel := context.WithTimeout(ctx, config.EtcdAzureTimeout)
+	defer cancel()
+	if _, err := container.GetProperties(toCtx, azblob.LeaseAccessConditions{}); err != nil {
+		return nil, errors.Wrapf(err, "azure storage container %s does not exist or is not accessible", config.EtcdAzureContainer)
+	}
+
+	return &azureStore{
+		config:    config,
+		container: container,
+	}, nil
+}
+
+func (s *azureStore) azureConfig() *AzureConfig {
+	return &AzureConfig{
+		Account:   s.config.EtcdAzureAccountName,
+		Container: s.config.EtcdAzureContainer,
+		Folder:    s.config.EtcdAzureFolder,
+	}
+}
+
+func (s *azureStore) blobName(basename string) string {
+	return path.Join(s.config.EtcdAzureFolder, basename)
+}
+
+// Upload uploads the given snapshot to the configured Azure Blob Storage
+// container.
+func (s *azureStore) Upload(ctx context.Context, snapshot string, meta UploadMetadata, extraMetadata *v1.ConfigMap) (*Snapshot, error) {
+	basename := filepath.Base(snapshot)
+	logrus.Infof("Uploading snapshot %s to Azure Blob Storage", basename)
+	sf := &Snapshot{
+		Name:      basename,
+		Backend:   BackendAzure,
+		CreatedAt: time.Now().Unix(),
+		Azure:     s.azureConfig(),
+	}
+	if strings.HasSuffix(snapshot, compressedExtension) {
+		sf.Compressed = true
+	}
+
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	blob := s.container.NewBlockBlobURL(s.blobName(basename))
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdAzureTimeout)
+	defer cancel()
+	_, err = azblob.UploadFileToBlockBlob(toCtx, f, blob, azblob.UploadToBlockBlobOptions{})
+	if err != nil {
+		sf.Status = failedSnapshotStatus
+		sf.Message = err.Error()
+		return sf, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return sf, err
+	}
+	sf.Status = successfulSnapshotStatus
+	sf.Size = info.Size()
+	return sf, nil
+}
+
+// Download downloads the given snapshot from the configured Azure Blob
+// Storage container.
+func (s *azureStore) Download(ctx context.Context) error {
+	blobName := s.blobName(s.config.ClusterResetRestorePath)
+	logrus.Debugf("retrieving snapshot: %s", blobName)
+
+	blob := s.container.NewBlockBlobURL(blobName)
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdAzureTimeout)
+	defer cancel()
+	resp, err := blob.Download(toCtx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil
+	}
+	body := resp.Body(azblob.RetryReaderOptions{})
+	defer body.Close()
+
+	snapshotDir, err := snapshotDir(s.config, true)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the snapshot dir")
+	}
+
+	fullSnapshotPath := filepath.Join(snapshotDir, s.config.ClusterResetRestorePath)
+	out, err := os.Create(fullSnapshotPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, body); err != nil {
+		return err
+	}
+
+	s.config.ClusterResetRestorePath = fullSnapshotPath
+
+	return os.Chmod(fullSnapshotPath, 0600)
+}
+
+// Prefix returns the blob name prefix under which this store's snapshots
+// are kept, rooted at EtcdAzureFolder.
+func (s *azureStore) Prefix() string {
+	return path.Join(s.config.EtcdAzureFolder, s.config.EtcdSnapshotName)
+}
+
+// Retention prunes snapshots in excess of EtcdSnapshotRetention from the
+// configured container, paging through ListBlobsFlatSegment with its
+// continuation marker since the Azure SDK has no single call that returns
+// every blob under a prefix at once.
+func (s *azureStore) Retention(ctx context.Context) error {
+	if s.config.EtcdSnapshotRetention < 1 {
+		return nil
+	}
+	logrus.Infof("Applying snapshot retention policy to snapshots stored in Azure Blob Storage: retention: %d, snapshotPrefix: %s", s.config.EtcdSnapshotRetention, s.Prefix())
+
+	var blobs []azblob.BlobItemInternal
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdAzureTimeout)
+	defer cancel()
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := s.container.ListBlobsFlatSegment(toCtx, marker, azblob.ListBlobsSegmentOptions{Prefix: s.Prefix()})
+		if err != nil {
+			return err
+		}
+		marker = resp.NextMarker
+		blobs = append(blobs, resp.Segment.BlobItems...)
+	}
+
+	if len(blobs) <= s.config.EtcdSnapshotRetention {
+		return nil
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[j].Properties.LastModified.Before(blobs[i].Properties.LastModified)
+	})
+
+	for _, b := range blobs[s.config.EtcdSnapshotRetention:] {
+		logrus.Infof("Removing Azure snapshot: %s", b.Name)
+		blob := s.container.NewBlockBlobURL(b.Name)
+		if _, err := blob.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List walks every blob page under EtcdAzureFolder and reconstructs each
+// snapshot's metadata from the blob name and properties - the Azure store
+// doesn't yet have an equivalent to S3's user metadata/tag lookups, so
+// creation time falls back to the blob's LastModified when it can't be
+// parsed out of the snapshot filename.
+func (s *azureStore) List(ctx context.Context) (map[string]Snapshot, error) {
+	snapshots := make(map[string]Snapshot)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefix := s.config.EtcdAzureFolder
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := s.container.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		marker = resp.NextMarker
+
+		for _, b := range resp.Segment.BlobItems {
+			if b.Properties.ContentLength == nil || *b.Properties.ContentLength == 0 {
+				continue
+			}
+			filename := path.Base(b.Name)
+			basename, compressed := strings.CutSuffix(filename, compressedExtension)
+			ts, err := parseSnapshotTimestamp(basename)
+			if err != nil {
+				ts = b.Properties.LastModified.Unix()
+			}
+
+			sf := Snapshot{
+				Name:       filename,
+				Backend:    BackendAzure,
+				CreatedAt:  ts,
+				Size:       *b.Properties.ContentLength,
+				Azure:      s.azureConfig(),
+				Status:     successfulSnapshotStatus,
+				Compressed: compressed,
+			}
+			snapshots[generateSnapshotConfigMapKey(sf)] = sf
+		}
+	}
+	return snapshots, nil
+}
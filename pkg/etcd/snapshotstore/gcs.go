@@ -0,0 +1,245 @@
+package snapshotstore
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// GCSConfig is the connection information recorded against a snapshot
+// uploaded to Google Cloud Storage, so that a restore on a fresh node knows
+// how to fetch it back again.
+type GCSConfig struct {
+	Bucket string
+	Folder string
+}
+
+// gcsStore is the Google Cloud Storage implementation of SnapshotStore.
+type gcsStore struct {
+	config *config.Control
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+// NewGCSStore creates a SnapshotStore backed by Google Cloud Storage, using
+// the EtcdGCS* fields of config.Control.
+func NewGCSStore(ctx context.Context, config *config.Control) (SnapshotStore, error) {
+	if config.EtcdGCSBucketName == "" {
+		return nil, errors.New("gcs bucket name was not set")
+	}
+
+	var opts []option.ClientOption
+	if config.EtcdGCSCredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(config.EtcdGCSCredentialsFile))
+	}
+
+	toCtx, cancel := context.WithTimeout(ctx, config.EtcdGCSTimeout)
+	defer cancel()
+	c, err := storage.NewClient(toCtx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := c.Bucket(config.EtcdGCSBucketName)
+	if _, err := bucket.Attrs(toCtx); err != nil {
+		return nil, errors.Wrapf(err, "gcs bucket %s does not exist or is not accessible", config.EtcdGCSBucketName)
+	}
+
+	return &gcsStore{
+		config: config,
+		client: c,
+		bucket: bucket,
+	}, nil
+}
+
+func (s *gcsStore) gcsConfig() *GCSConfig {
+	return &GCSConfig{
+		Bucket: s.config.EtcdGCSBucketName,
+		Folder: s.config.EtcdGCSFolder,
+	}
+}
+
+func (s *gcsStore) objectName(basename string) string {
+	return path.Join(s.config.EtcdGCSFolder, basename)
+}
+
+// Upload uploads the given snapshot to the configured GCS bucket.
+func (s *gcsStore) Upload(ctx context.Context, snapshot string, meta UploadMetadata, extraMetadata *v1.ConfigMap) (*Snapshot, error) {
+	basename := filepath.Base(snapshot)
+	logrus.Infof("Uploading snapshot %s to GCS", basename)
+	sf := &Snapshot{
+		Name:      basename,
+		Backend:   BackendGCS,
+		CreatedAt: time.Now().Unix(),
+		GCS:       s.gcsConfig(),
+	}
+	if strings.HasSuffix(snapshot, compressedExtension) {
+		sf.Compressed = true
+	}
+
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdGCSTimeout)
+	defer cancel()
+	w := s.bucket.Object(s.objectName(basename)).NewWriter(toCtx)
+	size, err := io.Copy(w, f)
+	if err != nil {
+		w.Close()
+		sf.Status = failedSnapshotStatus
+		sf.Message = err.Error()
+		return sf, err
+	}
+	if err := w.Close(); err != nil {
+		sf.Status = failedSnapshotStatus
+		sf.Message = err.Error()
+		return sf, err
+	}
+
+	sf.Status = successfulSnapshotStatus
+	sf.Size = size
+	return sf, nil
+}
+
+// Download downloads the given snapshot from the configured GCS bucket.
+func (s *gcsStore) Download(ctx context.Context) error {
+	objectName := s.objectName(s.config.ClusterResetRestorePath)
+	logrus.Debugf("retrieving snapshot: %s", objectName)
+
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdGCSTimeout)
+	defer cancel()
+	r, err := s.bucket.Object(objectName).NewReader(toCtx)
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+
+	snapshotDir, err := snapshotDir(s.config, true)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the snapshot dir")
+	}
+
+	fullSnapshotPath := filepath.Join(snapshotDir, s.config.ClusterResetRestorePath)
+	out, err := os.Create(fullSnapshotPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+
+	s.config.ClusterResetRestorePath = fullSnapshotPath
+
+	return os.Chmod(fullSnapshotPath, 0600)
+}
+
+// Prefix returns the object name prefix under which this store's snapshots
+// are kept, rooted at EtcdGCSFolder.
+func (s *gcsStore) Prefix() string {
+	return path.Join(s.config.EtcdGCSFolder, s.config.EtcdSnapshotName)
+}
+
+// Retention prunes snapshots in excess of EtcdSnapshotRetention from the
+// configured bucket, draining the storage.Query iterator for Prefix() to
+// get the full object set before sorting and deleting the oldest excess.
+func (s *gcsStore) Retention(ctx context.Context) error {
+	if s.config.EtcdSnapshotRetention < 1 {
+		return nil
+	}
+	logrus.Infof("Applying snapshot retention policy to snapshots stored in GCS: retention: %d, snapshotPrefix: %s", s.config.EtcdSnapshotRetention, s.Prefix())
+
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdGCSTimeout)
+	defer cancel()
+
+	var objects []*storage.ObjectAttrs
+	it := s.bucket.Objects(toCtx, &storage.Query{Prefix: s.Prefix()})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		objects = append(objects, attrs)
+	}
+
+	if len(objects) <= s.config.EtcdSnapshotRetention {
+		return nil
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[j].Updated.Before(objects[i].Updated)
+	})
+
+	for _, obj := range objects[s.config.EtcdSnapshotRetention:] {
+		logrus.Infof("Removing GCS snapshot: %s", obj.Name)
+		if err := s.bucket.Object(obj.Name).Delete(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List iterates every object under EtcdGCSFolder and reconstructs each
+// snapshot's metadata from the object name and attrs - like Azure, GCS has
+// no tag/user-metadata lookup wired up yet, so creation time falls back to
+// the object's Updated timestamp when it can't be parsed out of the
+// filename.
+func (s *gcsStore) List(ctx context.Context) (map[string]Snapshot, error) {
+	snapshots := make(map[string]Snapshot)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: s.config.EtcdGCSFolder})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Size == 0 {
+			continue
+		}
+
+		filename := path.Base(attrs.Name)
+		basename, compressed := strings.CutSuffix(filename, compressedExtension)
+		ts, err := parseSnapshotTimestamp(basename)
+		if err != nil {
+			ts = attrs.Updated.Unix()
+		}
+
+		sf := Snapshot{
+			Name:       filename,
+			Backend:    BackendGCS,
+			CreatedAt:  ts,
+			Size:       attrs.Size,
+			GCS:        s.gcsConfig(),
+			Status:     successfulSnapshotStatus,
+			Compressed: compressed,
+		}
+		snapshots[generateSnapshotConfigMapKey(sf)] = sf
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,543 @@
+package snapshotstore
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	sseTypeS3  = "SSE-S3"
+	sseTypeKMS = "SSE-KMS"
+	sseTypeC   = "SSE-C"
+)
+
+// S3Config is the connection information recorded against a snapshot
+// uploaded to S3, so that a restore on a fresh node knows how to fetch it
+// back again.
+type S3Config struct {
+	Endpoint      string
+	EndpointCA    string
+	SkipSSLVerify bool
+	Bucket        string
+	Region        string
+	Folder        string
+	Insecure      bool
+	SSEType       string
+}
+
+// s3Store is the S3-compatible (minio-go) implementation of SnapshotStore.
+type s3Store struct {
+	config *config.Control
+	client *minio.Client
+	sse    encrypt.ServerSide
+}
+
+// NewS3Store creates a SnapshotStore backed by an S3-compatible endpoint,
+// using the EtcdS3* fields of config.Control.
+func NewS3Store(ctx context.Context, config *config.Control) (SnapshotStore, error) {
+	if config.EtcdS3BucketName == "" {
+		return nil, errors.New("s3 bucket name was not set")
+	}
+	tr := http.DefaultTransport
+
+	switch {
+	case config.EtcdS3EndpointCA != "":
+		trCA, err := setTransportCA(tr, config.EtcdS3EndpointCA, config.EtcdS3SkipSSLVerify)
+		if err != nil {
+			return nil, err
+		}
+		tr = trCA
+	case config.EtcdS3 && config.EtcdS3SkipSSLVerify:
+		tr.(*http.Transport).TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: config.EtcdS3SkipSSLVerify,
+		}
+	}
+
+	var creds *credentials.Credentials
+	if len(config.EtcdS3AccessKey) == 0 && len(config.EtcdS3SecretKey) == 0 {
+		creds = credentials.NewIAM("") // for running on ec2 instance
+	} else {
+		creds = credentials.NewStaticV4(config.EtcdS3AccessKey, config.EtcdS3SecretKey, "")
+	}
+
+	opt := minio.Options{
+		Creds:        creds,
+		Secure:       !config.EtcdS3Insecure,
+		Region:       config.EtcdS3Region,
+		Transport:    tr,
+		BucketLookup: bucketLookupType(config.EtcdS3Endpoint),
+	}
+	c, err := minio.New(config.EtcdS3Endpoint, &opt)
+	if err != nil {
+		return nil, err
+	}
+
+	sse, err := newServerSideEncryption(config)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Checking if S3 bucket %s exists", config.EtcdS3BucketName)
+
+	ctx, cancel := context.WithTimeout(ctx, config.EtcdS3Timeout)
+	defer cancel()
+
+	exists, err := c.BucketExists(ctx, config.EtcdS3BucketName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket: %s does not exist", config.EtcdS3BucketName)
+	}
+	logrus.Infof("S3 bucket %s exists", config.EtcdS3BucketName)
+
+	store := &s3Store{
+		config: config,
+		client: c,
+		sse:    sse,
+	}
+
+	if store.lifecycleEnabled() {
+		if err := store.reconcileLifecycleRule(ctx, retentionDays(config)); err != nil {
+			return nil, errors.Wrap(err, "failed to reconcile S3 bucket lifecycle rule")
+		}
+	}
+
+	if store.objectLockEnabled() {
+		if err := store.verifyObjectLockConfigured(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// newServerSideEncryption builds the encrypt.ServerSide to use for uploads
+// and downloads, based on the EtcdS3SSEType configuration. SSE-S3 and
+// SSE-KMS require no key material on our end; SSE-C requires that we hold
+// the same 32-byte key used to encrypt the object so that we can supply it
+// again on download and HEAD requests.
+func newServerSideEncryption(config *config.Control) (encrypt.ServerSide, error) {
+	switch strings.ToUpper(config.EtcdS3SSEType) {
+	case "":
+		return nil, nil
+	case sseTypeS3:
+		return encrypt.NewSSE(), nil
+	case sseTypeKMS:
+		if config.EtcdS3SSEKMSKeyID == "" {
+			return nil, errors.New("etcd-s3-sse-kms-key-id must be set when etcd-s3-sse-type is sse-kms")
+		}
+		return encrypt.NewSSEKMS(config.EtcdS3SSEKMSKeyID, nil)
+	case sseTypeC:
+		key, err := readS3SSECKey(config.EtcdS3SSECKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read etcd-s3-sse-c key file")
+		}
+		return encrypt.NewSSEC(key)
+	default:
+		return nil, fmt.Errorf("unknown etcd-s3-sse-type %q", config.EtcdS3SSEType)
+	}
+}
+
+// readS3SSECKey reads a 32-byte SSE-C key from disk. The file may contain
+// either the raw 32-byte key or a base64-encoded copy of it.
+func readS3SSECKey(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		return nil, errors.New("etcd-s3-sse-c-key-file must be set when etcd-s3-sse-type is sse-c")
+	}
+	raw, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	raw = []byte(strings.TrimSpace(string(raw)))
+	if len(raw) == 32 {
+		return raw, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(string(raw))
+	if err != nil {
+		return nil, errors.New("sse-c key must be exactly 32 bytes, or base64-encoded 32 bytes")
+	}
+	if len(key) != 32 {
+		return nil, errors.New("sse-c key must be exactly 32 bytes, or base64-encoded 32 bytes")
+	}
+	return key, nil
+}
+
+func (s *s3Store) s3Config() *S3Config {
+	return &S3Config{
+		Endpoint:      s.config.EtcdS3Endpoint,
+		EndpointCA:    s.config.EtcdS3EndpointCA,
+		SkipSSLVerify: s.config.EtcdS3SkipSSLVerify,
+		Bucket:        s.config.EtcdS3BucketName,
+		Region:        s.config.EtcdS3Region,
+		Folder:        s.config.EtcdS3Folder,
+		Insecure:      s.config.EtcdS3Insecure,
+		SSEType:       s.config.EtcdS3SSEType,
+	}
+}
+
+// Upload uploads the given snapshot to the configured S3 compatible backend,
+// tagging and annotating it with enough metadata (cluster ID, node name,
+// versions, checksum) that listSnapshots can rebuild a Snapshot without
+// having to parse it back out of the filename.
+func (s *s3Store) Upload(ctx context.Context, snapshot string, meta UploadMetadata, extraMetadata *v1.ConfigMap) (*Snapshot, error) {
+	logrus.Infof("Uploading snapshot %s to S3", snapshot)
+	basename := filepath.Base(snapshot)
+	compressed := strings.HasSuffix(snapshot, compressedExtension)
+
+	checksum, err := sha256File(snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to checksum snapshot")
+	}
+
+	sf := &Snapshot{
+		Name:       basename,
+		Backend:    BackendS3,
+		CreatedAt:  time.Now().Unix(),
+		Compressed: compressed,
+		Checksum:   checksum,
+		S3:         s.s3Config(),
+	}
+
+	tagMap, userMetadata := snapshotMetadata(meta, compressed, checksum)
+	sf.Metadata = userMetadata
+
+	snapshotKey := path.Join(s.config.EtcdS3Folder, basename)
+
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdS3Timeout)
+	defer cancel()
+	opts := minio.PutObjectOptions{
+		NumThreads:           2,
+		ServerSideEncryption: s.sse,
+		UserTags:             tagMap,
+		UserMetadata:         userMetadata,
+		SendContentMd5:       true,
+	}
+	s.applyUploadTuning(&opts)
+	if compressed {
+		opts.ContentType = "application/zip"
+	} else {
+		opts.ContentType = "application/octet-stream"
+	}
+	if retainUntil := s.applyObjectLock(&opts); !retainUntil.IsZero() {
+		sf.LockMode = strings.ToUpper(s.config.EtcdS3ObjectLockMode)
+		sf.RetainUntil = retainUntil.Unix()
+	}
+	uploadInfo, err := s.uploadWithRetry(toCtx, s.config.EtcdS3BucketName, snapshotKey, snapshot, opts)
+	if err != nil {
+		sf.Status = failedSnapshotStatus
+		sf.Message = base64.StdEncoding.EncodeToString([]byte(err.Error()))
+	} else {
+		sf.Status = successfulSnapshotStatus
+		sf.Size = uploadInfo.Size
+	}
+	return sf, err
+}
+
+// Download downloads the given snapshot from the configured S3 compatible
+// backend.
+func (s *s3Store) Download(ctx context.Context) error {
+	snapshotKey := path.Join(s.config.EtcdS3Folder, s.config.ClusterResetRestorePath)
+
+	logrus.Debugf("retrieving snapshot: %s", snapshotKey)
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdS3Timeout)
+	defer cancel()
+
+	if strings.EqualFold(s.config.EtcdS3SSEType, sseTypeC) && s.sse == nil {
+		return errors.New("snapshot was stored using SSE-C but no etcd-s3-sse-c-key-file was configured")
+	}
+
+	getOpts := minio.GetObjectOptions{}
+	if s.sse != nil && s.sse.Type() == encrypt.SSEC {
+		getOpts.ServerSideEncryption = s.sse
+	}
+
+	stat, err := s.client.StatObject(toCtx, s.config.EtcdS3BucketName, snapshotKey, minio.StatObjectOptions{ServerSideEncryption: getOpts.ServerSideEncryption})
+	if err != nil {
+		return nil
+	}
+
+	snapshotDir, err := snapshotDir(s.config, true)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the snapshot dir")
+	}
+
+	fullSnapshotPath := filepath.Join(snapshotDir, s.config.ClusterResetRestorePath)
+	sf, err := os.Create(fullSnapshotPath)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+
+	if s.config.EtcdS3ParallelUploads > 1 && s.config.EtcdS3PartSize > 0 && stat.Size > int64(s.config.EtcdS3PartSize) {
+		err = s.downloadRanges(toCtx, s.config.EtcdS3BucketName, snapshotKey, sf, stat.Size, getOpts)
+	} else {
+		var r *minio.Object
+		if r, err = s.client.GetObject(toCtx, s.config.EtcdS3BucketName, snapshotKey, getOpts); err == nil {
+			defer r.Close()
+			_, err = io.CopyN(sf, r, stat.Size)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.config.ClusterResetRestorePath = fullSnapshotPath
+
+	return os.Chmod(fullSnapshotPath, 0600)
+}
+
+// Prefix returns the object key prefix under which this store's snapshots
+// are kept, rooted at EtcdS3Folder.
+func (s *s3Store) Prefix() string {
+	return path.Join(s.config.EtcdS3Folder, s.config.EtcdSnapshotName)
+}
+
+// listObjects returns every object under the given prefix in this store's
+// bucket.
+func (s *s3Store) listObjects(ctx context.Context, prefix string) ([]minio.ObjectInfo, error) {
+	var objects []minio.ObjectInfo
+	loo := minio.ListObjectsOptions{
+		Recursive: true,
+		Prefix:    prefix,
+	}
+	for info := range s.client.ListObjects(ctx, s.config.EtcdS3BucketName, loo) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, info)
+	}
+	return objects, nil
+}
+
+// Retention prunes snapshots in the configured S3 compatible backend for
+// this specific node. When etcd-s3-retention-mode is "lifecycle", pruning
+// is instead handled by a bucket lifecycle rule reconciled in NewS3Store,
+// and this is a no-op.
+func (s *s3Store) Retention(ctx context.Context) error {
+	if s.lifecycleEnabled() {
+		return nil
+	}
+	if s.config.EtcdSnapshotRetention < 1 {
+		return nil
+	}
+	logrus.Infof("Applying snapshot retention policy to snapshots stored in S3: retention: %d, snapshotPrefix: %s", s.config.EtcdSnapshotRetention, s.Prefix())
+
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdS3Timeout)
+	defer cancel()
+
+	tagFilter, err := parseTagFilter(s.config.EtcdS3TagFilter)
+	if err != nil {
+		return err
+	}
+
+	allFiles, err := s.listObjects(toCtx, s.Prefix())
+	if err != nil {
+		return err
+	}
+
+	var snapshotFiles []minio.ObjectInfo
+	for _, obj := range allFiles {
+		match, err := s.matchesTagFilter(toCtx, obj, tagFilter)
+		if err != nil {
+			return err
+		}
+		if match {
+			snapshotFiles = append(snapshotFiles, obj)
+		}
+	}
+
+	if len(snapshotFiles) <= s.config.EtcdSnapshotRetention {
+		return nil
+	}
+
+	// sort newest-first so we can prune entries past the retention count
+	sort.Slice(snapshotFiles, func(i, j int) bool {
+		return snapshotFiles[j].LastModified.Before(snapshotFiles[i].LastModified)
+	})
+
+	for _, df := range snapshotFiles[s.config.EtcdSnapshotRetention:] {
+		logrus.Infof("Removing S3 snapshot: %s", df.Key)
+		if err := s.removeObjectRespectingLock(ctx, df.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listConcurrency bounds how many per-object StatObject/GetObjectTagging/
+// GetObjectRetention calls List issues at once, so listing a large, shared
+// bucket doesn't serialize one extra round trip per stored snapshot.
+const listConcurrency = 16
+
+// List provides a list of currently stored snapshots in S3 along with their
+// relevant metadata. Building each entry's Metadata/Checksum (and, with a
+// tag filter or object lock configured, its tags/retention) takes a
+// StatObject call per object, so that work is fanned out across
+// listConcurrency workers instead of done one object at a time.
+func (s *s3Store) List(ctx context.Context) (map[string]Snapshot, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tagFilter, err := parseTagFilter(s.config.EtcdS3TagFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	var loo minio.ListObjectsOptions
+	if s.config.EtcdS3Folder != "" {
+		loo = minio.ListObjectsOptions{
+			Prefix:    s.config.EtcdS3Folder,
+			Recursive: true,
+		}
+	}
+
+	var objects []minio.ObjectInfo
+	for obj := range s.client.ListObjects(ctx, s.config.EtcdS3BucketName, loo) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if obj.Size == 0 {
+			continue
+		}
+		objects = append(objects, obj)
+	}
+
+	entries := make([]*Snapshot, len(objects))
+	errs := make([]error, len(objects))
+	sem := make(chan struct{}, listConcurrency)
+	var wg sync.WaitGroup
+	for i, obj := range objects {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, obj minio.ObjectInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			entries[i], errs[i] = s.snapshotFromObject(ctx, obj, tagFilter)
+		}(i, obj)
+	}
+	wg.Wait()
+
+	snapshots := make(map[string]Snapshot, len(objects))
+	for i, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+		if entries[i] == nil {
+			continue // filtered out by tagFilter
+		}
+		snapshots[generateSnapshotConfigMapKey(*entries[i])] = *entries[i]
+	}
+	return snapshots, nil
+}
+
+// snapshotFromObject builds the Snapshot entry for a single listed object,
+// or returns a nil Snapshot if it doesn't match tagFilter.
+func (s *s3Store) snapshotFromObject(ctx context.Context, obj minio.ObjectInfo, tagFilter map[string]string) (*Snapshot, error) {
+	match, err := s.matchesTagFilter(ctx, obj, tagFilter)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, nil
+	}
+
+	filename := path.Base(obj.Key)
+	_, compressed := strings.CutSuffix(filename, compressedExtension)
+
+	stat, err := s.client.StatObject(ctx, s.config.EtcdS3BucketName, obj.Key, minio.StatObjectOptions{ServerSideEncryption: s.sse})
+	if err != nil {
+		return nil, err
+	}
+
+	sf := &Snapshot{
+		Name:       filename,
+		Backend:    BackendS3,
+		CreatedAt:  obj.LastModified.Unix(),
+		Size:       obj.Size,
+		S3:         s.s3Config(),
+		Status:     successfulSnapshotStatus,
+		Compressed: compressed,
+		Checksum:   stat.UserMetadata[metaChecksum],
+		Metadata:   stat.UserMetadata,
+	}
+	if s.objectLockEnabled() {
+		if mode, retainUntil, err := s.client.GetObjectRetention(ctx, s.config.EtcdS3BucketName, obj.Key, ""); err == nil && mode != nil {
+			sf.LockMode = string(*mode)
+			if retainUntil != nil {
+				sf.RetainUntil = retainUntil.Unix()
+			}
+		}
+	}
+	return sf, nil
+}
+
+func readS3EndpointCA(endpointCA string) ([]byte, error) {
+	ca, err := base64.StdEncoding.DecodeString(endpointCA)
+	if err != nil {
+		return os.ReadFile(endpointCA)
+	}
+	return ca, nil
+}
+
+func setTransportCA(tr http.RoundTripper, endpointCA string, insecureSkipVerify bool) (http.RoundTripper, error) {
+	ca, err := readS3EndpointCA(endpointCA)
+	if err != nil {
+		return tr, err
+	}
+	if !isValidCertificate(ca) {
+		return tr, errors.New("endpoint-ca is not a valid x509 certificate")
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AppendCertsFromPEM(ca)
+
+	tr.(*http.Transport).TLSClientConfig = &tls.Config{
+		RootCAs:            certPool,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	return tr, nil
+}
+
+// isValidCertificate checks to see if the given byte slice is a valid x509
+// certificate.
+func isValidCertificate(c []byte) bool {
+	p, _ := pem.Decode(c)
+	if p == nil {
+		return false
+	}
+	if _, err := x509.ParseCertificates(p.Bytes); err != nil {
+		return false
+	}
+	return true
+}
+
+func bucketLookupType(endpoint string) minio.BucketLookupType {
+	if strings.Contains(endpoint, "aliyun") { // backwards compt with RKE1
+		return minio.BucketLookupDNS
+	}
+	return minio.BucketLookupAuto
+}
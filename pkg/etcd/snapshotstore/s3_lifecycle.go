@@ -0,0 +1,231 @@
+package snapshotstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RetentionModeLifecycle is the --etcd-s3-retention-mode value that
+// delegates pruning of old snapshots to an S3 bucket lifecycle rule instead
+// of client-side ListObjects/RemoveObject calls.
+const RetentionModeLifecycle = "lifecycle"
+
+// snapshotTagKey/snapshotTagValue mark objects uploaded by us so the
+// lifecycle rule's tag filter never touches unrelated objects in a shared
+// bucket.
+const (
+	snapshotTagKey   = "k3s-snapshot"
+	snapshotTagValue = "true"
+)
+
+func (s *s3Store) lifecycleEnabled() bool {
+	return strings.EqualFold(s.config.EtcdS3RetentionMode, RetentionModeLifecycle)
+}
+
+// snapshotTags returns the object tags applied to every snapshot we upload.
+func snapshotTags() (*tags.Tags, error) {
+	return tags.NewTags(map[string]string{snapshotTagKey: snapshotTagValue}, false)
+}
+
+// reconcileLifecycleRule ensures the bucket has a lifecycle rule that
+// expires objects under our snapshot prefix after retentionDays, and aborts
+// incomplete multipart uploads so failed uploads don't accumulate. It only
+// calls SetBucketLifecycle when the computed rule differs from what is
+// already configured.
+func (s *s3Store) reconcileLifecycleRule(ctx context.Context, days int) error {
+	if days < 1 {
+		return errors.New("etcd-s3-retention-days must be set to a positive number of days when etcd-s3-retention-mode is lifecycle")
+	}
+
+	ruleID := "k3s-snapshot-retention"
+	want := lifecycle.NewConfiguration()
+	want.Rules = []lifecycle.Rule{
+		{
+			ID:     ruleID,
+			Status: "Enabled",
+			// S3 only allows one of Prefix/Tag/And directly on a Filter, so
+			// scoping to both the snapshot prefix and the k3s-snapshot tag
+			// requires nesting both under And.
+			RuleFilter: lifecycle.Filter{
+				And: lifecycle.And{
+					Prefix: s.Prefix(),
+					Tags: []lifecycle.Tag{
+						{Key: snapshotTagKey, Value: snapshotTagValue},
+					},
+				},
+			},
+			Expiration: lifecycle.Expiration{
+				Days: lifecycle.ExpirationDays(days),
+			},
+			AbortIncompleteMultipartUpload: lifecycle.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: lifecycle.ExpirationDays(days),
+			},
+		},
+	}
+
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdS3Timeout)
+	defer cancel()
+
+	existing, err := s.client.GetBucketLifecycle(toCtx, s.config.EtcdS3BucketName)
+	if err != nil && !isLifecycleNotConfigured(err) {
+		return errors.Wrap(err, "failed to get bucket lifecycle configuration")
+	}
+
+	if existing != nil && lifecycleRuleEqual(existing, want, ruleID) {
+		return nil
+	}
+
+	logrus.Infof("Reconciling S3 bucket lifecycle rule %s for snapshot retention: %d days", ruleID, days)
+	return s.client.SetBucketLifecycle(toCtx, s.config.EtcdS3BucketName, want)
+}
+
+// lifecycleRuleEqual reports whether existing already contains a rule
+// matching want's rule, so we avoid calling SetBucketLifecycle when nothing
+// has changed.
+func lifecycleRuleEqual(existing, want *lifecycle.Configuration, ruleID string) bool {
+	for _, r := range existing.Rules {
+		if r.ID != ruleID {
+			continue
+		}
+		w := want.Rules[0]
+		return r.Status == w.Status &&
+			r.RuleFilter.And.Prefix == w.RuleFilter.And.Prefix &&
+			reflect.DeepEqual(r.RuleFilter.And.Tags, w.RuleFilter.And.Tags) &&
+			r.Expiration.Days == w.Expiration.Days &&
+			r.AbortIncompleteMultipartUpload.DaysAfterInitiation == w.AbortIncompleteMultipartUpload.DaysAfterInitiation
+	}
+	return false
+}
+
+func isLifecycleNotConfigured(err error) bool {
+	return err != nil && minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration"
+}
+
+// retentionDays derives the lifecycle expiration window from the explicit
+// --etcd-s3-retention-days flag if set, otherwise from the existing
+// retention-count/interval knobs so lifecycle mode approximates the same
+// retention window as client-side pruning.
+func retentionDays(config *config.Control) int {
+	if config.EtcdS3RetentionDays > 0 {
+		return config.EtcdS3RetentionDays
+	}
+	return config.EtcdSnapshotRetention * snapshotIntervalDays(config)
+}
+
+// snapshotIntervalDays converts the configured snapshot schedule's implied
+// interval into whole days, never below 1. A schedule more frequent than
+// daily (e.g. hourly cron) therefore yields 1, and the lifecycle expiration
+// equals EtcdSnapshotRetention days. EtcdSnapshotIntervalDays, when set, is an explicit override for schedules
+// intervalFromCronDays can't parse. Otherwise the interval is derived from
+// EtcdSnapshotCron, falling back to 1 day when the expression isn't one of
+// the "every N minutes/hours" shapes k3s's own snapshot cron defaults use.
+func snapshotIntervalDays(config *config.Control) int {
+	if config.EtcdSnapshotIntervalDays > 0 {
+		return config.EtcdSnapshotIntervalDays
+	}
+	if days, ok := intervalFromCronDays(config.EtcdSnapshotCron); ok {
+		return days
+	}
+	return 1
+}
+
+// intervalFromCronDays recognizes the "every N minutes" (`*/N * * * *`) and
+// "every N hours" (`0 */N * * *`) step shapes used by k3s's own
+// --etcd-snapshot-schedule-cron default and common overrides, returning the
+// implied interval rounded down to whole days. Any other expression
+// (explicit lists, day-of-month/weekday restrictions, ...) isn't parsed;
+// ok is false so the caller falls back to its own default.
+func intervalFromCronDays(cron string) (days int, ok bool) {
+	fields := strings.Fields(cron)
+	if len(fields) != 5 {
+		return 0, false
+	}
+	minute, hour, dom, month, dow := fields[0], fields[1], fields[2], fields[3], fields[4]
+	if dom != "*" || month != "*" || dow != "*" {
+		return 0, false
+	}
+
+	switch {
+	case hour == "*":
+		stepMinutes, ok := cronStep(minute)
+		if !ok {
+			return 0, false
+		}
+		return daysFromMinutes(stepMinutes), true
+	case minute == "0":
+		stepHours, ok := cronStep(hour)
+		if !ok {
+			return 0, false
+		}
+		return daysFromMinutes(stepHours * 60), true
+	default:
+		return 0, false
+	}
+}
+
+// cronStep parses a cron step field of the form "*/N", returning N.
+func cronStep(field string) (int, bool) {
+	rest, ok := strings.CutPrefix(field, "*/")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// daysFromMinutes rounds an interval given in minutes down to whole days,
+// never below 1 so a sub-daily schedule doesn't zero out the retention
+// window.
+func daysFromMinutes(minutes int) int {
+	if days := minutes / (24 * 60); days > 1 {
+		return days
+	}
+	return 1
+}
+
+// MigrateToTaggedSnapshots tags every existing, untagged snapshot under
+// this store's prefix with the k3s-snapshot tag, so that switching an
+// existing bucket over to lifecycle-mode retention doesn't immediately
+// expire backups the lifecycle rule's tag filter can't see yet.
+func (s *s3Store) MigrateToTaggedSnapshots(ctx context.Context) error {
+	want, err := snapshotTags()
+	if err != nil {
+		return err
+	}
+
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdS3Timeout)
+	defer cancel()
+
+	objects, err := s.listObjects(toCtx, s.Prefix())
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		existing, err := s.client.GetObjectTagging(ctx, s.config.EtcdS3BucketName, obj.Key, minio.GetObjectTaggingOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get tags of %s: %w", obj.Key, err)
+		}
+		if existing.ToMap()[snapshotTagKey] == snapshotTagValue {
+			continue
+		}
+		logrus.Infof("Tagging existing S3 snapshot for lifecycle retention: %s", obj.Key)
+		if err := s.client.PutObjectTagging(ctx, s.config.EtcdS3BucketName, obj.Key, want, minio.PutObjectTaggingOptions{}); err != nil {
+			return fmt.Errorf("failed to tag %s: %w", obj.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package snapshotstore
+
+import (
+	"testing"
+
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+func TestLifecycleRuleEqual(t *testing.T) {
+	want := &lifecycle.Configuration{
+		Rules: []lifecycle.Rule{
+			{
+				ID:     "k3s-snapshot-retention",
+				Status: "Enabled",
+				RuleFilter: lifecycle.Filter{
+					And: lifecycle.And{
+						Prefix: "snapshots/etcd-snapshot",
+						Tags:   []lifecycle.Tag{{Key: snapshotTagKey, Value: snapshotTagValue}},
+					},
+				},
+				Expiration:                     lifecycle.Expiration{Days: 30},
+				AbortIncompleteMultipartUpload: lifecycle.AbortIncompleteMultipartUpload{DaysAfterInitiation: 30},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		existing *lifecycle.Configuration
+		want     bool
+	}{
+		{
+			name:     "identical rule",
+			existing: want,
+			want:     true,
+		},
+		{
+			name: "different expiration",
+			existing: &lifecycle.Configuration{Rules: []lifecycle.Rule{
+				{ID: "k3s-snapshot-retention", Status: "Enabled",
+					RuleFilter: want.Rules[0].RuleFilter,
+					Expiration: lifecycle.Expiration{Days: 7}},
+			}},
+			want: false,
+		},
+		{
+			name: "different tags",
+			existing: &lifecycle.Configuration{Rules: []lifecycle.Rule{
+				{ID: "k3s-snapshot-retention", Status: "Enabled",
+					RuleFilter: lifecycle.Filter{And: lifecycle.And{
+						Prefix: want.Rules[0].RuleFilter.And.Prefix,
+						Tags:   []lifecycle.Tag{{Key: "other-tag", Value: "true"}},
+					}},
+					Expiration: want.Rules[0].Expiration},
+			}},
+			want: false,
+		},
+		{
+			name:     "rule not present",
+			existing: &lifecycle.Configuration{Rules: []lifecycle.Rule{{ID: "some-other-rule"}}},
+			want:     false,
+		},
+		{
+			name:     "no rules",
+			existing: &lifecycle.Configuration{},
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lifecycleRuleEqual(tt.existing, want, "k3s-snapshot-retention"); got != tt.want {
+				t.Errorf("lifecycleRuleEqual() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionDays(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *config.Control
+		want int
+	}{
+		{
+			name: "explicit retention days wins",
+			cfg:  &config.Control{EtcdS3RetentionDays: 14, EtcdSnapshotRetention: 5},
+			want: 14,
+		},
+		{
+			name: "derived from count and explicit interval",
+			cfg:  &config.Control{EtcdSnapshotRetention: 5, EtcdSnapshotIntervalDays: 2},
+			want: 10,
+		},
+		{
+			name: "derived from count and hourly cron",
+			cfg:  &config.Control{EtcdSnapshotRetention: 48, EtcdSnapshotCron: "0 */1 * * *"},
+			want: 48, // hourly snapshots round down to a 1 day interval, so retention collapses to the count
+		},
+		{
+			name: "falls back to 1 day interval when schedule can't be parsed",
+			cfg:  &config.Control{EtcdSnapshotRetention: 5, EtcdSnapshotCron: "0 0 1 * *"},
+			want: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retentionDays(tt.cfg); got != tt.want {
+				t.Errorf("retentionDays() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntervalFromCronDays(t *testing.T) {
+	tests := []struct {
+		cron     string
+		wantDays int
+		wantOk   bool
+	}{
+		{cron: "*/15 * * * *", wantDays: 1, wantOk: true},
+		{cron: "0 */6 * * *", wantDays: 1, wantOk: true},
+		{cron: "0 0 * * *", wantDays: 0, wantOk: false},
+		{cron: "0 0 1 * *", wantDays: 0, wantOk: false},
+		{cron: "not a cron expression", wantDays: 0, wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cron, func(t *testing.T) {
+			days, ok := intervalFromCronDays(tt.cron)
+			if ok != tt.wantOk || days != tt.wantDays {
+				t.Errorf("intervalFromCronDays(%q) = (%d, %v), want (%d, %v)", tt.cron, days, ok, tt.wantDays, tt.wantOk)
+			}
+		})
+	}
+}
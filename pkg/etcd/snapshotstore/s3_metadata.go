@@ -0,0 +1,110 @@
+package snapshotstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Metadata and tag keys recorded against every snapshot we upload. Tags are
+// used for filtering (list/retention, lifecycle rules); user metadata is
+// not searchable but is cheaper to read back in bulk via StatObject.
+const (
+	metaClusterID   = "cluster-id"
+	metaNodeName    = "node-name"
+	metaK3sVersion  = "k3s-version"
+	metaEtcdVersion = "etcd-version"
+	metaScheduled   = "scheduled"
+	metaCompressed  = "compressed"
+	metaChecksum    = "checksum-sha256"
+)
+
+// sha256File computes the SHA-256 checksum of the file at path.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// snapshotMetadata builds the tag set and user metadata map recorded
+// against a newly uploaded snapshot. Tags are kept to the fields operators
+// are likely to filter on; everything else goes in user metadata only.
+func snapshotMetadata(meta UploadMetadata, compressed bool, checksum string) (tagMap, userMetadata map[string]string) {
+	tagMap = map[string]string{
+		snapshotTagKey: snapshotTagValue,
+	}
+	if meta.ClusterID != "" {
+		tagMap[metaClusterID] = meta.ClusterID
+	}
+
+	userMetadata = map[string]string{
+		metaNodeName:    meta.NodeName,
+		metaK3sVersion:  meta.K3sVersion,
+		metaEtcdVersion: meta.EtcdVersion,
+		metaScheduled:   strconv.FormatBool(meta.Scheduled),
+		metaCompressed:  strconv.FormatBool(compressed),
+	}
+	if meta.ClusterID != "" {
+		userMetadata[metaClusterID] = meta.ClusterID
+	}
+	if checksum != "" {
+		userMetadata[metaChecksum] = checksum
+	}
+	return tagMap, userMetadata
+}
+
+// parseTagFilter parses the --etcd-s3-tag-filter value ("key=value,key=value")
+// into a map used to restrict listSnapshots/retention to a single cluster's
+// snapshots in a shared bucket.
+func parseTagFilter(filter string) (map[string]string, error) {
+	result := make(map[string]string)
+	if filter == "" {
+		return result, nil
+	}
+	for _, pair := range strings.Split(filter, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid etcd-s3-tag-filter entry %q, expected key=value", pair)
+		}
+		result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return result, nil
+}
+
+// matchesTagFilter reports whether obj's tags satisfy every key/value pair
+// in filter. An empty filter always matches.
+func (s *s3Store) matchesTagFilter(ctx context.Context, obj minio.ObjectInfo, filter map[string]string) (bool, error) {
+	if len(filter) == 0 {
+		return true, nil
+	}
+	objTags, err := s.client.GetObjectTagging(ctx, s.config.EtcdS3BucketName, obj.Key, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return false, err
+	}
+	tagMap := objTags.ToMap()
+	for k, v := range filter {
+		if tagMap[k] != v {
+			return false, nil
+		}
+	}
+	return true, nil
+}
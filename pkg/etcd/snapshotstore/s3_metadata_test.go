@@ -0,0 +1,83 @@
+package snapshotstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTagFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", filter: "", want: map[string]string{}},
+		{name: "single pair", filter: "cluster-id=prod", want: map[string]string{"cluster-id": "prod"}},
+		{
+			name:   "multiple pairs with spaces",
+			filter: "cluster-id=prod, env = staging",
+			want:   map[string]string{"cluster-id": "prod", "env": "staging"},
+		},
+		{name: "missing equals", filter: "cluster-id", wantErr: true},
+		{name: "trailing comma is ignored", filter: "cluster-id=prod,", want: map[string]string{"cluster-id": "prod"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTagFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTagFilter(%q) error = %v, wantErr %v", tt.filter, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTagFilter(%q) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotMetadata(t *testing.T) {
+	meta := UploadMetadata{
+		ClusterID:   "c-123",
+		NodeName:    "node-a",
+		K3sVersion:  "v1.30.0+k3s1",
+		EtcdVersion: "3.5.9",
+		Scheduled:   true,
+	}
+
+	tagMap, userMetadata := snapshotMetadata(meta, true, "deadbeef")
+
+	if tagMap[snapshotTagKey] != snapshotTagValue {
+		t.Errorf("tagMap missing snapshot tag: %v", tagMap)
+	}
+	if tagMap[metaClusterID] != "c-123" {
+		t.Errorf("tagMap missing cluster ID: %v", tagMap)
+	}
+
+	wantUserMetadata := map[string]string{
+		metaNodeName:    "node-a",
+		metaK3sVersion:  "v1.30.0+k3s1",
+		metaEtcdVersion: "3.5.9",
+		metaScheduled:   "true",
+		metaCompressed:  "true",
+		metaClusterID:   "c-123",
+		metaChecksum:    "deadbeef",
+	}
+	if !reflect.DeepEqual(userMetadata, wantUserMetadata) {
+		t.Errorf("userMetadata = %v, want %v", userMetadata, wantUserMetadata)
+	}
+}
+
+func TestSnapshotMetadataWithoutClusterIDOrChecksum(t *testing.T) {
+	_, userMetadata := snapshotMetadata(UploadMetadata{}, false, "")
+
+	if _, ok := userMetadata[metaClusterID]; ok {
+		t.Errorf("expected no cluster-id key when ClusterID is empty, got %v", userMetadata)
+	}
+	if _, ok := userMetadata[metaChecksum]; ok {
+		t.Errorf("expected no checksum key when checksum is empty, got %v", userMetadata)
+	}
+}
@@ -0,0 +1,259 @@
+package snapshotstore
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// resumeUploadIDMetaKey is the user-metadata key whose value (the snapshot
+// checksum) must be set for findResumableUpload to look for an in-progress
+// multipart upload at all. The value is not compared against the upload
+// found; that upload is matched by object key only.
+const resumeUploadIDMetaKey = metaChecksum
+
+// applyUploadTuning fills in the part size, thread count, and checksum
+// knobs an operator can tune for large snapshots on slow links. Leaving the
+// flags unset preserves the previous NumThreads: 2, no-PartSize behavior.
+func (s *s3Store) applyUploadTuning(opts *minio.PutObjectOptions) {
+	if s.config.EtcdS3ParallelUploads > 0 {
+		opts.NumThreads = uint(s.config.EtcdS3ParallelUploads)
+	}
+	if s.config.EtcdS3PartSize > 0 {
+		opts.PartSize = uint64(s.config.EtcdS3PartSize)
+		// AutoChecksum reaches only NewMultipartUpload: with PartSize set,
+		// uploadMultipartParts sends each part with its SSE options alone,
+		// so no per-part checksum is computed or sent.
+		opts.AutoChecksum = minio.ChecksumCRC32C
+	}
+}
+
+// uploadMaxRetries returns the total number of upload attempts: the first
+// attempt plus the configured retry count. Leaving the flag unset gives a
+// single attempt (no retry), preserving old behavior.
+func (s *s3Store) uploadMaxRetries() int {
+	if s.config.EtcdS3MaxRetries > 0 {
+		return s.config.EtcdS3MaxRetries + 1
+	}
+	return 1
+}
+
+// uploadWithRetry uploads snapshot to key. When multipart tuning isn't
+// enabled (opts.PartSize == 0) this preserves the original single
+// FPutObject-per-attempt behavior. When it is enabled, uploading is done via
+// uploadMultipartWithRetry instead of FPutObject: FPutObject drives its own
+// internal multipart session and aborts it as soon as it returns an error,
+// which leaves nothing in progress for a later attempt to resume. Owning the
+// session ourselves across attempts is what actually makes retries resume
+// rather than reupload every part.
+func (s *s3Store) uploadWithRetry(ctx context.Context, bucket, key, snapshot string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	if opts.PartSize == 0 {
+		var lastErr error
+		for attempt := 1; attempt <= s.uploadMaxRetries(); attempt++ {
+			info, err := s.client.FPutObject(ctx, bucket, key, snapshot, opts)
+			if err == nil {
+				return info, nil
+			}
+			lastErr = err
+			logrus.Warnf("Snapshot upload attempt %d/%d to %s failed: %v", attempt, s.uploadMaxRetries(), key, err)
+		}
+		return minio.UploadInfo{}, lastErr
+	}
+	return s.uploadMultipartWithRetry(ctx, bucket, key, snapshot, opts)
+}
+
+// uploadMultipartWithRetry drives a single multipart session directly via
+// minio.Core across every retry attempt: it starts (or resumes) one
+// NewMultipartUpload, re-lists whichever parts actually landed after a
+// failed attempt, and only re-sends the parts still missing before calling
+// CompleteMultipartUpload. The upload ID is kept across attempts instead of
+// being abandoned on error, which is what lets a retry skip parts the
+// previous attempt already uploaded.
+func (s *s3Store) uploadMultipartWithRetry(ctx context.Context, bucket, key, snapshot string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	core := &minio.Core{Client: s.client}
+
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	uploadID, uploaded, err := s.findResumableUpload(ctx, core, bucket, key, opts.UserMetadata[resumeUploadIDMetaKey])
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= s.uploadMaxRetries(); attempt++ {
+		if uploadID == "" {
+			uploadID, err = core.NewMultipartUpload(ctx, bucket, key, opts)
+			if err != nil {
+				return minio.UploadInfo{}, err
+			}
+			uploaded = nil
+		}
+
+		info, err := s.uploadMultipartParts(ctx, core, bucket, key, uploadID, f, stat.Size(), opts, uploaded)
+		if err == nil {
+			return info, nil
+		}
+		lastErr = err
+		logrus.Warnf("Multipart upload attempt %d/%d of %s failed: %v", attempt, s.uploadMaxRetries(), key, err)
+
+		// Find out which parts actually landed before the failure so the next
+		// attempt only re-sends what's missing. If we can't even list parts
+		// for this session anymore, abandon it and start a fresh one.
+		if parts, listErr := core.ListObjectParts(ctx, bucket, key, uploadID, 0, 10000); listErr == nil {
+			uploaded = make(map[int]minio.ObjectPart, len(parts.ObjectParts))
+			for _, p := range parts.ObjectParts {
+				uploaded[p.PartNumber] = p
+			}
+		} else {
+			uploadID = ""
+		}
+	}
+	return minio.UploadInfo{}, lastErr
+}
+
+// uploadMultipartParts uploads whichever parts of f are missing from
+// uploaded, then completes the multipart upload. Parts already present in
+// uploaded (from an earlier attempt or a resumed session) are reused as-is.
+// Parts are sent one at a time; opts.NumThreads is not applied here.
+func (s *s3Store) uploadMultipartParts(ctx context.Context, core *minio.Core, bucket, key, uploadID string, f *os.File, size int64, opts minio.PutObjectOptions, uploaded map[int]minio.ObjectPart) (minio.UploadInfo, error) {
+	partSize := int64(opts.PartSize)
+	var parts []minio.CompletePart
+	for partNumber, offset := 1, int64(0); offset < size; partNumber, offset = partNumber+1, offset+partSize {
+		partLen := partSize
+		if remaining := size - offset; remaining < partLen {
+			partLen = remaining
+		}
+		if existing, ok := uploaded[partNumber]; ok {
+			parts = append(parts, minio.CompletePart{PartNumber: partNumber, ETag: existing.ETag})
+			continue
+		}
+		part, err := core.PutObjectPart(ctx, bucket, key, uploadID, partNumber, io.NewSectionReader(f, offset, partLen), partLen, minio.PutObjectPartOptions{SSE: opts.ServerSideEncryption})
+		if err != nil {
+			return minio.UploadInfo{}, errors.Wrapf(err, "failed to upload part %d", partNumber)
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: partNumber, ETag: part.ETag})
+	}
+
+	return core.CompleteMultipartUpload(ctx, bucket, key, uploadID, parts, minio.PutObjectOptions{})
+}
+
+// downloadRanges fetches a large object as a set of concurrent ranged GETs,
+// writing each range directly to its offset in f so no part ever needs to
+// be buffered in memory. f is pre-extended to size so writes can land at
+// arbitrary offsets.
+func (s *s3Store) downloadRanges(ctx context.Context, bucket, key string, f *os.File, size int64, getOpts minio.GetObjectOptions) error {
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+
+	partSize := int64(s.config.EtcdS3PartSize)
+	numWorkers := s.config.EtcdS3ParallelUploads
+
+	type byteRange struct{ offset, length int64 }
+	ranges := make(chan byteRange, numWorkers)
+	// abort is closed the moment any worker hits its first error, so the
+	// feeder stops blocking on a full ranges channel instead of leaking:
+	// every worker exits on its first error without draining the rest of
+	// ranges, and a multi-GB download has far more ranges than the
+	// channel's buffer.
+	abort := make(chan struct{})
+	var abortOnce sync.Once
+	go func() {
+		defer close(ranges)
+		for offset := int64(0); offset < size; offset += partSize {
+			length := partSize
+			if remaining := size - offset; remaining < length {
+				length = remaining
+			}
+			select {
+			case ranges <- byteRange{offset, length}:
+			case <-abort:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range ranges {
+				opts := getOpts
+				if err := opts.SetRange(r.offset, r.offset+r.length-1); err != nil {
+					errs <- err
+					abortOnce.Do(func() { close(abort) })
+					return
+				}
+				obj, err := s.client.GetObject(ctx, bucket, key, opts)
+				if err != nil {
+					errs <- err
+					abortOnce.Do(func() { close(abort) })
+					return
+				}
+				_, err = io.Copy(io.NewOffsetWriter(f, r.offset), obj)
+				obj.Close()
+				if err != nil {
+					errs <- errors.Wrapf(err, "failed to download range %d-%d", r.offset, r.offset+r.length-1)
+					abortOnce.Do(func() { close(abort) })
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findResumableUpload looks for an in-progress multipart upload of key,
+// returning its upload ID and already-uploaded parts. It looks only when
+// checksum is non-empty, and takes the first upload whose key matches; the
+// upload's content is not compared with checksum.
+func (s *s3Store) findResumableUpload(ctx context.Context, core *minio.Core, bucket, key, checksum string) (string, map[int]minio.ObjectPart, error) {
+	if checksum == "" {
+		return "", nil, nil
+	}
+
+	result, err := core.ListMultipartUploads(ctx, bucket, key, "", "", "", 1000)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, upload := range result.Uploads {
+		if upload.Key != key {
+			continue
+		}
+		parts, err := core.ListObjectParts(ctx, bucket, key, upload.UploadID, 0, 10000)
+		if err != nil {
+			continue
+		}
+		uploaded := make(map[int]minio.ObjectPart, len(parts.ObjectParts))
+		for _, p := range parts.ObjectParts {
+			uploaded[p.PartNumber] = p
+		}
+		return upload.UploadID, uploaded, nil
+	}
+	return "", nil, nil
+}
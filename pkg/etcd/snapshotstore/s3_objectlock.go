@@ -0,0 +1,86 @@
+package snapshotstore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const objectLockModeGovernance = "GOVERNANCE"
+
+// objectLockEnabled reports whether snapshot uploads should be placed under
+// S3 Object Lock (WORM) retention.
+func (s *s3Store) objectLockEnabled() bool {
+	return s.config.EtcdS3ObjectLockMode != ""
+}
+
+// verifyObjectLockConfigured errors out if object locking was requested but
+// the bucket does not have Object Lock enabled - uploads would otherwise
+// silently land without the retention guarantee operators asked for.
+func (s *s3Store) verifyObjectLockConfigured(ctx context.Context) error {
+	toCtx, cancel := context.WithTimeout(ctx, s.config.EtcdS3Timeout)
+	defer cancel()
+
+	objectLock, mode, _, _, err := s.client.GetObjectLockConfig(toCtx, s.config.EtcdS3BucketName)
+	if err != nil {
+		return errors.Wrap(err, "failed to get bucket object lock configuration")
+	}
+	if !strings.EqualFold(objectLock, "Enabled") {
+		return errors.Errorf("etcd-s3-object-lock-mode is set but bucket %s does not have object locking enabled", s.config.EtcdS3BucketName)
+	}
+	if mode == nil || !strings.EqualFold(string(*mode), s.config.EtcdS3ObjectLockMode) {
+		logrus.Warnf("Bucket %s default object lock mode does not match etcd-s3-object-lock-mode %s; per-object mode will be set explicitly on each upload", s.config.EtcdS3BucketName, s.config.EtcdS3ObjectLockMode)
+	}
+	return nil
+}
+
+// applyObjectLock sets the PutObjectOptions fields needed to place the
+// upload under Object Lock retention, and returns the retain-until time (or
+// the zero time if locking isn't enabled) so it can be recorded on the
+// Snapshot.
+func (s *s3Store) applyObjectLock(opts *minio.PutObjectOptions) time.Time {
+	if !s.objectLockEnabled() {
+		return time.Time{}
+	}
+	retainUntil := time.Now().Add(s.config.EtcdS3ObjectLockRetention)
+	opts.Mode = minio.RetentionMode(strings.ToUpper(s.config.EtcdS3ObjectLockMode))
+	opts.RetainUntilDate = retainUntil
+	if s.config.EtcdS3LegalHold {
+		opts.LegalHold = minio.LegalHoldEnabled
+	}
+	return retainUntil
+}
+
+// removeObjectRespectingLock deletes a snapshot object, bypassing
+// governance-mode retention when the caller is configured to do so.
+// Objects that can't be removed because they're still under retention are
+// logged and skipped rather than failing the whole retention cycle - an
+// operator-visible warning beats an entirely-failed prune. Any other
+// error (network, permissions, ...) is returned as-is so it isn't mistaken
+// for a locked object.
+func (s *s3Store) removeObjectRespectingLock(ctx context.Context, key string) error {
+	opts := minio.RemoveObjectOptions{}
+	if s.objectLockEnabled() && strings.EqualFold(s.config.EtcdS3ObjectLockMode, objectLockModeGovernance) && s.config.EtcdS3ObjectLockBypassGovernance {
+		opts.GovernanceBypass = true
+	}
+
+	err := s.client.RemoveObject(ctx, s.config.EtcdS3BucketName, key, opts)
+	if err != nil && s.objectLockEnabled() && isObjectLockedError(err) {
+		logrus.Warnf("Skipping removal of locked S3 snapshot %s: %v", key, err)
+		return nil
+	}
+	return err
+}
+
+// isObjectLockedError reports whether err is the error S3 returns when a
+// delete is denied because the object is still under Object Lock
+// retention, as opposed to an unrelated access or transient failure that
+// should fail the retention cycle rather than be silently skipped.
+func isObjectLockedError(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.Code == "AccessDenied" && strings.Contains(strings.ToLower(resp.Message), "object is under")
+}
@@ -0,0 +1,60 @@
+package snapshotstore
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadS3SSECKey(t *testing.T) {
+	rawKey := make([]byte, 32)
+	for i := range rawKey {
+		rawKey[i] = byte(i)
+	}
+
+	writeKeyFile := func(t *testing.T, contents []byte) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "sse-c.key")
+		if err := os.WriteFile(path, contents, 0600); err != nil {
+			t.Fatalf("failed to write key file: %v", err)
+		}
+		return path
+	}
+
+	t.Run("raw 32-byte key", func(t *testing.T) {
+		path := writeKeyFile(t, rawKey)
+		got, err := readS3SSECKey(path)
+		if err != nil {
+			t.Fatalf("readS3SSECKey() error = %v", err)
+		}
+		if string(got) != string(rawKey) {
+			t.Errorf("readS3SSECKey() = %x, want %x", got, rawKey)
+		}
+	})
+
+	t.Run("base64-encoded key with trailing newline", func(t *testing.T) {
+		encoded := []byte(base64.StdEncoding.EncodeToString(rawKey) + "\n")
+		path := writeKeyFile(t, encoded)
+		got, err := readS3SSECKey(path)
+		if err != nil {
+			t.Fatalf("readS3SSECKey() error = %v", err)
+		}
+		if string(got) != string(rawKey) {
+			t.Errorf("readS3SSECKey() = %x, want %x", got, rawKey)
+		}
+	})
+
+	t.Run("wrong length", func(t *testing.T) {
+		path := writeKeyFile(t, []byte("too-short"))
+		if _, err := readS3SSECKey(path); err == nil {
+			t.Error("readS3SSECKey() expected error for wrong-length key, got nil")
+		}
+	})
+
+	t.Run("empty path", func(t *testing.T) {
+		if _, err := readS3SSECKey(""); err == nil {
+			t.Error("readS3SSECKey() expected error for empty key file path, got nil")
+		}
+	})
+}
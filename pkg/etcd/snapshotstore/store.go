@@ -0,0 +1,117 @@
+// Package snapshotstore defines the interface used by the etcd snapshot
+// manager to upload, download, list, and prune etcd snapshots, along with
+// the backend implementations (S3, Azure Blob, GCS) that satisfy it.
+//
+// This package only defines the backends and reads their settings off
+// config.Control; config.Control's fields and the --etcd-* CLI flags that
+// populate them (pkg/daemons/config.Control, pkg/cli/cmds'
+// NewEtcdSnapshotFlags/ApplyEtcdSnapshotFlags) live outside this package.
+// What's still missing is the glue in the real pkg/etcd/etcd.go snapshot
+// manager and pkg/cli/cmds/server.go that calls newSnapshotStore and wires
+// NewEtcdSnapshotFlags/ApplyEtcdSnapshotFlags into the server command -
+// neither file is part of this checkout.
+package snapshotstore
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Backend names, used both for the --etcd-backup-backend flag and as the
+// discriminator recorded in Snapshot.Backend.
+const (
+	BackendS3    = "s3"
+	BackendAzure = "azure"
+	BackendGCS   = "gcs"
+)
+
+// SnapshotStore is implemented by each supported etcd snapshot storage
+// backend. The etcd snapshot manager talks to whichever backend is
+// configured exclusively through this interface, so scheduling, retention,
+// and snapshot ConfigMap reconciliation behave identically regardless of
+// where snapshots actually live.
+type SnapshotStore interface {
+	// Upload uploads the snapshot at the given local path and returns
+	// metadata describing the stored object.
+	Upload(ctx context.Context, snapshotPath string, meta UploadMetadata, extraMetadata *v1.ConfigMap) (*Snapshot, error)
+	// Download retrieves the snapshot named by the restore path in the
+	// store's configuration into the local snapshot directory.
+	Download(ctx context.Context) error
+	// List returns the snapshots currently held by the backend, keyed the
+	// same way as entries in the snapshot ConfigMap.
+	List(ctx context.Context) (map[string]Snapshot, error)
+	// Retention prunes snapshots in excess of the configured retention
+	// count.
+	Retention(ctx context.Context) error
+	// Prefix returns the key/path prefix under which this store keeps its
+	// snapshots.
+	Prefix() string
+}
+
+// TagMigrator is implemented by stores that support converting existing,
+// untagged snapshots over to the tag scheme lifecycle-mode retention relies
+// on. It is satisfied by s3Store; MigrateToTaggedSnapshots is the entry
+// point callers should use instead of asserting against the unexported
+// store type directly. The `k3s etcd-snapshot migrate-tags` subcommand
+// (pkg/cli/cmds.NewEtcdSnapshotMigrateTagsCommand) is what an operator
+// actually runs; its Action is expected to build a SnapshotStore via
+// newSnapshotStore and call MigrateToTaggedSnapshots on it.
+type TagMigrator interface {
+	MigrateToTaggedSnapshots(ctx context.Context) error
+}
+
+// MigrateToTaggedSnapshots tags store's existing, untagged snapshots so an
+// operator can switch an already-populated bucket over to
+// --etcd-s3-retention-mode=lifecycle without the lifecycle rule's tag
+// filter immediately treating every pre-existing snapshot as unmanaged. It
+// returns an error if the backend doesn't support migration.
+func MigrateToTaggedSnapshots(ctx context.Context, store SnapshotStore) error {
+	migrator, ok := store.(TagMigrator)
+	if !ok {
+		return fmt.Errorf("%T does not support migrating untagged snapshots", store)
+	}
+	return migrator.MigrateToTaggedSnapshots(ctx)
+}
+
+// UploadMetadata is the identifying information the caller attaches to a
+// snapshot at upload time. Stores that support it record this as object
+// tags and/or metadata, so that a shared bucket can later be filtered down
+// to a single cluster's snapshots.
+type UploadMetadata struct {
+	ClusterID   string
+	NodeName    string
+	K3sVersion  string
+	EtcdVersion string
+	Scheduled   bool
+}
+
+// Snapshot is the backend-agnostic metadata recorded for a single stored
+// snapshot. It is converted to/from the etcd package's snapshotFile type by
+// each store's caller.
+type Snapshot struct {
+	Name       string
+	Backend    string
+	Size       int64
+	Compressed bool
+	Status     string
+	Message    string
+	CreatedAt  int64
+	// Checksum is the SHA-256 of the local snapshot file at upload time.
+	Checksum string
+	// LockMode and RetainUntil record S3 Object Lock (WORM) retention
+	// applied to this snapshot, if any.
+	LockMode    string
+	RetainUntil int64
+	// Metadata carries the backend's view of this snapshot's tags/user
+	// metadata (cluster ID, node name, versions, scheduled, checksum, ...).
+	Metadata map[string]string
+
+	// S3, Azure, and GCS carry the backend-specific connection details
+	// needed to locate this snapshot again on restore. Exactly one is set,
+	// matching Backend.
+	S3    *S3Config
+	Azure *AzureConfig
+	GCS   *GCSConfig
+}
@@ -0,0 +1,46 @@
+package snapshotstore
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+)
+
+const (
+	compressedExtension = ".zip"
+
+	successfulSnapshotStatus = "successful"
+	failedSnapshotStatus     = "failed"
+)
+
+// snapshotDir returns the directory used to store local copies of
+// snapshots, creating it if requested and it does not already exist.
+func snapshotDir(config *config.Control, create bool) (string, error) {
+	dir := config.EtcdSnapshotDir
+	if dir == "" {
+		dir = filepath.Join(config.DataDir, "db", "snapshots")
+	}
+	if create {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// parseSnapshotTimestamp extracts the unix timestamp suffix that scheduled
+// snapshot names are created with, e.g. "etcd-snapshot-1136189045".
+func parseSnapshotTimestamp(basename string) (int64, error) {
+	return strconv.ParseInt(basename[strings.LastIndexByte(basename, '-')+1:], 10, 64)
+}
+
+// generateSnapshotConfigMapKey builds the key used to store this snapshot's
+// metadata in the snapshot ConfigMap, namespaced by backend so that entries
+// from different stores never collide. Entries from different nodes are told
+// apart only by the snapshot name itself.
+func generateSnapshotConfigMapKey(sf Snapshot) string {
+	return strings.ReplaceAll(sf.Backend+"-"+sf.Name, ".", "_")
+}